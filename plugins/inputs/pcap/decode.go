@@ -0,0 +1,108 @@
+package pcap
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// decodePacket turns a single captured packet into a packetRecord, decoding
+// only the layers the user asked for via the `layers` config option.
+func decodePacket(measurement, iface string, linkType layers.LinkType, data []byte, ci gopacket.CaptureInfo, wanted map[string]bool) *packetRecord {
+	packet := gopacket.NewPacket(data, linkType, gopacket.Lazy)
+
+	timestamp := ci.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	rec := &packetRecord{
+		measurement: measurement,
+		tags:        map[string]string{"interface": iface},
+		fields:      map[string]interface{}{"len": ci.Length},
+		timestamp:   timestamp,
+	}
+
+	if wanted["ethernet"] {
+		if eth, ok := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet); ok {
+			rec.tags["src_mac"] = eth.SrcMAC.String()
+			rec.tags["dst_mac"] = eth.DstMAC.String()
+		}
+	}
+
+	var proto string
+	if wanted["ip"] {
+		if ip4, ok := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			rec.tags["src_ip"] = ip4.SrcIP.String()
+			rec.tags["dst_ip"] = ip4.DstIP.String()
+			proto = ip4.Protocol.String()
+		} else if ip6, ok := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6); ok {
+			rec.tags["src_ip"] = ip6.SrcIP.String()
+			rec.tags["dst_ip"] = ip6.DstIP.String()
+			proto = ip6.NextHeader.String()
+		}
+	}
+
+	if wanted["tcp"] {
+		if tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+			proto = "TCP"
+			rec.fields["sport"] = int(tcp.SrcPort)
+			rec.fields["dport"] = int(tcp.DstPort)
+			rec.fields["tcp_flags"] = tcpFlagString(tcp)
+		}
+	}
+
+	if wanted["udp"] {
+		if udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); ok {
+			proto = "UDP"
+			rec.fields["sport"] = int(udp.SrcPort)
+			rec.fields["dport"] = int(udp.DstPort)
+		}
+	}
+
+	if wanted["dns"] {
+		if dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS); ok {
+			rec.fields["dns_qr"] = dns.QR
+			if len(dns.Questions) > 0 {
+				rec.fields["dns_query"] = string(dns.Questions[0].Name)
+			}
+		}
+	}
+
+	if wanted["tls"] {
+		if tls, ok := packet.Layer(layers.LayerTypeTLS).(*layers.TLS); ok && len(tls.Handshake) > 0 {
+			rec.fields["tls_handshake"] = true
+		}
+	}
+
+	if proto != "" {
+		rec.tags["proto"] = proto
+	}
+
+	return rec
+}
+
+func tcpFlagString(tcp *layers.TCP) string {
+	var flags []string
+	if tcp.SYN {
+		flags = append(flags, "SYN")
+	}
+	if tcp.ACK {
+		flags = append(flags, "ACK")
+	}
+	if tcp.FIN {
+		flags = append(flags, "FIN")
+	}
+	if tcp.RST {
+		flags = append(flags, "RST")
+	}
+	if tcp.PSH {
+		flags = append(flags, "PSH")
+	}
+	if tcp.URG {
+		flags = append(flags, "URG")
+	}
+	return strings.Join(flags, "|")
+}
@@ -0,0 +1,348 @@
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tsharkDecoder turns one tshark output stream into a sequence of
+// packetRecords. Implementations are selected via `tshark_format`; all of
+// them ultimately funnel their fields through buildPacketRecord so tag
+// selection, timestamp parsing, and typed field overrides behave the same
+// regardless of the wire format tshark was asked to emit.
+type tsharkDecoder interface {
+	// decode reads a full tshark output stream, calling emit for every
+	// decoded packet. Recoverable per-packet errors are reported via
+	// onError and decoding continues; a non-nil return means the stream
+	// itself could not be parsed any further.
+	decode(r io.Reader, emit func(*packetRecord), onError func(error)) error
+}
+
+func newTsharkDecoder(p *PCAP) (tsharkDecoder, error) {
+	switch strings.ToLower(p.TsharkFormat) {
+	case "", "csv":
+		return &csvTsharkDecoder{plugin: p}, nil
+	case "json":
+		return &jsonTsharkDecoder{plugin: p}, nil
+	case "ek":
+		return &ekTsharkDecoder{plugin: p}, nil
+	case "pdml":
+		return &pdmlTsharkDecoder{plugin: p}, nil
+	default:
+		return nil, fmt.Errorf("unknown `tshark_format` %q, must be one of `csv`, `json`, `ek`, `pdml`", p.TsharkFormat)
+	}
+}
+
+// tsharkFormatArgs returns the tshark output-format flags to append after
+// the user's own tshark_args. csv is unchanged from the original plugin:
+// the user supplies "-T fields -E separator=," plus one "-e" per column
+// themselves. The other formats are self-describing, so tshark only needs
+// to be told which one to emit.
+func tsharkFormatArgs(format string) []string {
+	switch strings.ToLower(format) {
+	case "json":
+		return []string{"-T", "json"}
+	case "ek":
+		return []string{"-T", "ek"}
+	case "pdml":
+		return []string{"-T", "pdml"}
+	default:
+		return nil
+	}
+}
+
+// typeOverrides builds a field-name-keyed lookup from the parallel
+// csv_column_names/csv_column_types lists, so non-CSV decoders can still
+// apply explicit typed overrides to auto-discovered fields.
+func (p *PCAP) typeOverrides() map[string]string {
+	overrides := make(map[string]string, len(p.CSVColumnNames))
+	for i, name := range p.CSVColumnNames {
+		if i < len(p.CSVColumnTypes) {
+			overrides[name] = p.CSVColumnTypes[i]
+		}
+	}
+	return overrides
+}
+
+// buildPacketRecord converts a flat field-name -> value map into a
+// packetRecord, consulting tag_fields, csv_timestamp_column/format, and any
+// typed overrides along the way. All four decoders call this once they have
+// reduced their own wire format down to this shape.
+func buildPacketRecord(p *PCAP, values map[string]string, onError func(error)) *packetRecord {
+	rec := &packetRecord{
+		measurement: p.CSVMeasurementName,
+		tags:        make(map[string]string),
+		fields:      make(map[string]interface{}),
+		timestamp:   time.Now(),
+	}
+
+	overrides := p.typeOverrides()
+	tagSet := make(map[string]bool, len(p.TagFields))
+	for _, t := range p.TagFields {
+		tagSet[t] = true
+	}
+
+	for name, val := range values {
+		if tagSet[name] {
+			rec.tags[name] = val
+			continue
+		}
+
+		if name == p.CSVTimestampColumn {
+			var parsedTime time.Time
+			var err error
+			if p.CSVTimestampFormat == "unix" {
+				// Seconds since the epoch, optionally fractional (e.g. frame.time_epoch).
+				var secs float64
+				if secs, err = strconv.ParseFloat(val, 64); err == nil {
+					parsedTime = time.Unix(0, int64(secs*float64(time.Second)))
+				}
+			} else {
+				parsedTime, err = time.Parse(p.CSVTimestampFormat, val)
+			}
+			if err != nil {
+				onError(fmt.Errorf("failed to parse timestamp '%s' with format '%s' for field '%s': %w",
+					val, p.CSVTimestampFormat, name, err))
+			} else {
+				rec.timestamp = parsedTime
+			}
+			continue
+		}
+
+		switch strings.ToLower(overrides[name]) {
+		case "int":
+			if parsedVal, err := strconv.Atoi(val); err != nil {
+				onError(fmt.Errorf("failed to parse int for field '%s' value '%s': %w", name, val, err))
+			} else {
+				rec.fields[name] = parsedVal
+			}
+		case "float":
+			if parsedVal, err := strconv.ParseFloat(val, 64); err != nil {
+				onError(fmt.Errorf("failed to parse float64 for field '%s' value '%s': %w", name, val, err))
+			} else {
+				rec.fields[name] = parsedVal
+			}
+		case "bool":
+			if parsedVal, err := strconv.ParseBool(val); err != nil {
+				onError(fmt.Errorf("failed to parse bool for field '%s' value '%s': %w", name, val, err))
+			} else {
+				rec.fields[name] = parsedVal
+			}
+		default: // "string" or unknown type
+			rec.fields[name] = val
+		}
+	}
+
+	return rec
+}
+
+// csvTsharkDecoder is the original decoder: tshark is invoked with
+// `-T fields -E separator=,` plus an explicit `-e` per column, and
+// csv_column_names gives the positional name for each column.
+type csvTsharkDecoder struct {
+	plugin *PCAP
+}
+
+func (d *csvTsharkDecoder) decode(r io.Reader, emit func(*packetRecord), onError func(error)) error {
+	p := d.plugin
+	csvReader := csv.NewReader(r)
+	csvReader.Comma = ','
+
+	numExpectedEntries := len(p.CSVColumnNames)
+
+	for lno := 0; ; lno++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			onError(fmt.Errorf("failed to read CSV record at line %d: %w", lno, err))
+			continue
+		}
+
+		if len(record) != numExpectedEntries {
+			onError(fmt.Errorf("CSV record at line %d has %d entries, but expected %d based on csv_column_names",
+				lno, len(record), numExpectedEntries))
+			continue
+		}
+
+		values := make(map[string]string, numExpectedEntries)
+		for i, name := range p.CSVColumnNames {
+			values[name] = record[i]
+		}
+
+		emit(buildPacketRecord(p, values, onError))
+	}
+}
+
+// jsonTsharkDecoder handles `tshark -T json`, a single JSON array of
+// per-packet objects shaped like {"_source": {"layers": {...}}}.
+type jsonTsharkDecoder struct {
+	plugin *PCAP
+}
+
+type tsharkJSONPacket struct {
+	Source struct {
+		Layers map[string]map[string]interface{} `json:"layers"`
+	} `json:"_source"`
+}
+
+func (d *jsonTsharkDecoder) decode(r io.Reader, emit func(*packetRecord), onError func(error)) error {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("failed to read start of tshark JSON output: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected tshark JSON output to start with '[', got %v", tok)
+	}
+
+	for dec.More() {
+		var pkt tsharkJSONPacket
+		if err := dec.Decode(&pkt); err != nil {
+			onError(fmt.Errorf("failed to decode tshark JSON packet: %w", err))
+			continue
+		}
+		emit(buildPacketRecord(d.plugin, flattenTsharkLayers(pkt.Source.Layers), onError))
+	}
+
+	return nil
+}
+
+// ekTsharkDecoder handles `tshark -T ek`, newline-delimited JSON suitable
+// for streaming: each packet is preceded by an Elasticsearch bulk "index"
+// action line, followed by a document line with top-level "layers".
+type ekTsharkDecoder struct {
+	plugin *PCAP
+}
+
+func (d *ekTsharkDecoder) decode(r io.Reader, emit func(*packetRecord), onError func(error)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var action map[string]json.RawMessage
+		if err := json.Unmarshal(line, &action); err != nil {
+			onError(fmt.Errorf("failed to parse EK line: %w", err))
+			continue
+		}
+		if _, isIndexAction := action["index"]; isIndexAction {
+			continue
+		}
+
+		var doc struct {
+			Layers map[string]map[string]interface{} `json:"layers"`
+		}
+		if err := json.Unmarshal(line, &doc); err != nil {
+			onError(fmt.Errorf("failed to decode EK packet document: %w", err))
+			continue
+		}
+
+		emit(buildPacketRecord(d.plugin, flattenTsharkLayers(doc.Layers), onError))
+	}
+
+	return scanner.Err()
+}
+
+// flattenTsharkLayers reduces tshark's per-protocol field maps (shared by
+// the json and ek formats) down to a single field-name -> string-value map.
+// Nested "*_tree" sub-objects are skipped; `tshark_format = "pdml"` exposes
+// those if needed.
+func flattenTsharkLayers(layers map[string]map[string]interface{}) map[string]string {
+	out := make(map[string]string)
+	for _, fields := range layers {
+		for name, raw := range fields {
+			switch val := raw.(type) {
+			case string:
+				out[name] = val
+			case float64:
+				out[name] = strconv.FormatFloat(val, 'f', -1, 64)
+			case []interface{}:
+				if len(val) > 0 {
+					if s, ok := val[0].(string); ok {
+						out[name] = s
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// pdmlTsharkDecoder handles `tshark -T pdml`, an XML dump of every proto and
+// field tshark's dissectors produced for each packet.
+type pdmlTsharkDecoder struct {
+	plugin *PCAP
+}
+
+type pdmlField struct {
+	Name   string      `xml:"name,attr"`
+	Show   string      `xml:"show,attr"`
+	Fields []pdmlField `xml:"field"`
+}
+
+type pdmlProto struct {
+	Fields []pdmlField `xml:"field"`
+}
+
+type pdmlPacket struct {
+	Protos []pdmlProto `xml:"proto"`
+}
+
+func (d *pdmlTsharkDecoder) decode(r io.Reader, emit func(*packetRecord), onError func(error)) error {
+	dec := xml.NewDecoder(r)
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read PDML token: %w", err)
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "packet" {
+			continue
+		}
+
+		var pkt pdmlPacket
+		if err := dec.DecodeElement(&pkt, &se); err != nil {
+			onError(fmt.Errorf("failed to decode PDML packet: %w", err))
+			continue
+		}
+
+		values := make(map[string]string)
+		for _, proto := range pkt.Protos {
+			flattenPDMLFields(proto.Fields, values)
+		}
+
+		emit(buildPacketRecord(d.plugin, values, onError))
+	}
+}
+
+func flattenPDMLFields(fields []pdmlField, out map[string]string) {
+	for _, f := range fields {
+		if f.Name != "" && f.Show != "" {
+			out[f.Name] = f.Show
+		}
+		flattenPDMLFields(f.Fields, out)
+	}
+}
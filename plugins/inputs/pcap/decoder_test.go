@@ -0,0 +1,150 @@
+package pcap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPacketRecordTagsTimestampAndTypes(t *testing.T) {
+	p := &PCAP{
+		CSVMeasurementName: "pcap",
+		TagFields:          []string{"src_ip"},
+		CSVTimestampColumn: "timestamp",
+		CSVTimestampFormat: "unix",
+		CSVColumnNames:     []string{"timestamp", "src_ip", "len"},
+		CSVColumnTypes:     []string{"float", "string", "int"},
+	}
+
+	var gotErr error
+	rec := buildPacketRecord(p, map[string]string{
+		"timestamp": "1700000000",
+		"src_ip":    "10.0.0.1",
+		"len":       "66",
+	}, func(err error) { gotErr = err })
+
+	require.NoError(t, gotErr)
+	require.Equal(t, "pcap", rec.measurement)
+	require.Equal(t, "10.0.0.1", rec.tags["src_ip"])
+	require.Equal(t, 66, rec.fields["len"])
+	require.EqualValues(t, 1700000000, rec.timestamp.Unix())
+}
+
+func TestBuildPacketRecordReportsBadTypedValue(t *testing.T) {
+	p := &PCAP{
+		CSVMeasurementName: "pcap",
+		CSVColumnNames:     []string{"len"},
+		CSVColumnTypes:     []string{"int"},
+	}
+
+	var gotErr error
+	rec := buildPacketRecord(p, map[string]string{"len": "not-a-number"}, func(err error) { gotErr = err })
+
+	require.Error(t, gotErr)
+	require.NotContains(t, rec.fields, "len")
+}
+
+func TestFlattenTsharkLayers(t *testing.T) {
+	layers := map[string]map[string]interface{}{
+		"ip": {
+			"ip.src":  "10.0.0.1",
+			"ip.len":  float64(66),
+			"ip.list": []interface{}{"first", "second"},
+		},
+	}
+
+	got := flattenTsharkLayers(layers)
+	require.Equal(t, "10.0.0.1", got["ip.src"])
+	require.Equal(t, "66", got["ip.len"])
+	require.Equal(t, "first", got["ip.list"])
+}
+
+func TestCSVTsharkDecoder(t *testing.T) {
+	p := &PCAP{
+		CSVMeasurementName: "pcap",
+		CSVColumnNames:     []string{"timestamp", "src_ip"},
+		TagFields:          []string{"src_ip"},
+	}
+	d := &csvTsharkDecoder{plugin: p}
+
+	var recs []*packetRecord
+	err := d.decode(strings.NewReader("1700000000,10.0.0.1\n1700000001,10.0.0.2\n"),
+		func(r *packetRecord) { recs = append(recs, r) },
+		func(error) { t.Fatal("unexpected decode error") })
+
+	require.NoError(t, err)
+	require.Len(t, recs, 2)
+	require.Equal(t, "10.0.0.1", recs[0].tags["src_ip"])
+	require.Equal(t, "10.0.0.2", recs[1].tags["src_ip"])
+}
+
+func TestCSVTsharkDecoderReportsColumnMismatch(t *testing.T) {
+	p := &PCAP{CSVColumnNames: []string{"timestamp", "src_ip"}}
+	d := &csvTsharkDecoder{plugin: p}
+
+	var errs []error
+	err := d.decode(strings.NewReader("1700000000\n"),
+		func(*packetRecord) { t.Fatal("should not emit a record for a short row") },
+		func(e error) { errs = append(errs, e) })
+
+	require.NoError(t, err)
+	require.Len(t, errs, 1)
+}
+
+func TestJSONTsharkDecoder(t *testing.T) {
+	p := &PCAP{CSVMeasurementName: "pcap", TagFields: []string{"ip.src"}}
+	d := &jsonTsharkDecoder{plugin: p}
+
+	input := `[{"_source":{"layers":{"ip":{"ip.src":"10.0.0.1","ip.len":"66"}}}}]`
+
+	var recs []*packetRecord
+	err := d.decode(strings.NewReader(input),
+		func(r *packetRecord) { recs = append(recs, r) },
+		func(error) { t.Fatal("unexpected decode error") })
+
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	require.Equal(t, "10.0.0.1", recs[0].tags["ip.src"])
+	require.Equal(t, "66", recs[0].fields["ip.len"])
+}
+
+func TestEKTsharkDecoderSkipsIndexActionLines(t *testing.T) {
+	p := &PCAP{CSVMeasurementName: "pcap", TagFields: []string{"ip.src"}}
+	d := &ekTsharkDecoder{plugin: p}
+
+	input := `{"index":{}}
+{"layers":{"ip":{"ip.src":"10.0.0.1"}}}
+`
+
+	var recs []*packetRecord
+	err := d.decode(strings.NewReader(input),
+		func(r *packetRecord) { recs = append(recs, r) },
+		func(error) { t.Fatal("unexpected decode error") })
+
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	require.Equal(t, "10.0.0.1", recs[0].tags["ip.src"])
+}
+
+func TestPDMLTsharkDecoder(t *testing.T) {
+	p := &PCAP{CSVMeasurementName: "pcap", TagFields: []string{"ip.src"}}
+	d := &pdmlTsharkDecoder{plugin: p}
+
+	input := `<pdml>
+  <packet>
+    <proto name="ip">
+      <field name="ip.src" show="10.0.0.1"/>
+    </proto>
+  </packet>
+</pdml>`
+
+	var recs []*packetRecord
+	err := d.decode(strings.NewReader(input),
+		func(r *packetRecord) { recs = append(recs, r) },
+		func(error) { t.Fatal("unexpected decode error") })
+
+	require.NoError(t, err)
+	require.Len(t, recs, 1)
+	require.Equal(t, "10.0.0.1", recs[0].tags["ip.src"])
+}
@@ -0,0 +1,271 @@
+package pcap
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// fileSource implements the original tshark-based workflow: it expects a
+// list of already-closed pcap files, shells out to tshark, and renames each
+// file out of the way while it is being processed so capture tools writing
+// new files do not race with us. The tshark output itself is turned into
+// packetRecords by a tsharkDecoder, chosen via `tshark_format`.
+//
+// Besides the static `files` list, files to process can also come from
+// `files_from` (re-read every Gather) and `watch_dirs` (via watcher, an
+// fsnotify-backed dirWatcher that is nil unless watch_dirs is configured).
+type fileSource struct {
+	plugin  *PCAP
+	decoder tsharkDecoder
+	watcher *dirWatcher
+}
+
+func newFileSource(p *PCAP, decoder tsharkDecoder) *fileSource {
+	return &fileSource{plugin: p, decoder: decoder}
+}
+
+func (s *fileSource) gather(acc telegraf.Accumulator) error {
+	p := s.plugin
+	if p.TsharkPath == "" {
+		return fmt.Errorf("`tshark_path` is not configured")
+	}
+
+	tmpDir := p.TmpDir
+	if tmpDir == "" {
+		tmpDir = os.TempDir()
+	}
+
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return fmt.Errorf("failed to created temporary directory %s: %w", tmpDir, err)
+	}
+
+	files := s.resolveFiles(acc)
+
+	// Run up to max_concurrent_files through tshark at once; per-file
+	// errors are isolated via acc.AddError inside processFile, so one bad
+	// capture can't take down the rest of the batch.
+	sem := make(chan struct{}, p.MaxConcurrentFiles)
+	var wg sync.WaitGroup
+	for _, f := range files {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(f fileToProcess) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.processFile(acc, f, tmpDir)
+		}(f)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// fileToProcess is one path resolveFiles found to process this Gather.
+// recreate is true only for entries from the static `files` list, the
+// original rotating-writer case where the configured path is expected to
+// keep existing: processFile recreates an empty placeholder there after
+// renaming it away. files_from and watch_dirs paths point at a capture that
+// is genuinely finished, so recreating an empty file at that path would just
+// make resolveFiles pick it back up next interval and reprocess it forever.
+type fileToProcess struct {
+	path     string
+	recreate bool
+}
+
+// resolveFiles collects every file to process this Gather: the static
+// `files` list, one newline-delimited path per line of `files_from` (if
+// set, re-read every call so it can be appended to between intervals), and
+// any files the directory watcher has found stable since the last call.
+func (s *fileSource) resolveFiles(acc telegraf.Accumulator) []fileToProcess {
+	p := s.plugin
+	var files []fileToProcess
+
+	for _, path := range p.Files {
+		files = append(files, fileToProcess{path: path, recreate: true})
+	}
+
+	if p.FilesFrom != "" {
+		data, err := os.ReadFile(p.FilesFrom)
+		if err != nil {
+			acc.AddError(fmt.Errorf("failed to read `files_from` %s: %w", p.FilesFrom, err))
+		} else {
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				files = append(files, fileToProcess{path: line})
+			}
+		}
+	}
+
+	if s.watcher != nil {
+		s.watcher.drainErrors(acc)
+		for _, path := range s.watcher.drainReady() {
+			files = append(files, fileToProcess{path: path})
+		}
+	}
+
+	return files
+}
+
+func (s *fileSource) processFile(acc telegraf.Accumulator, f fileToProcess, tmpDir string) {
+	p := s.plugin
+	originalFilepath := f.path
+
+	tmpFilename := fmt.Sprintf("%s.pcap.processing", filepath.Base(originalFilepath))
+	tmpFilepath := filepath.Join(tmpDir, tmpFilename)
+
+	err := os.Rename(originalFilepath, tmpFilepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			acc.AddError(fmt.Errorf("original PCAP file %s does not exist, skipping. It might have been rotated or cleand up.",
+				originalFilepath))
+		} else {
+			acc.AddError(fmt.Errorf("failed to rename original PCAP file %s to %s: %w",
+				originalFilepath, tmpFilepath, err))
+		}
+		return
+	}
+
+	if f.recreate {
+		newFile, err := os.Create(originalFilepath)
+		if err != nil {
+			acc.AddError(fmt.Errorf("failed to create new empty PCAP file %s after renaming: %w. "+
+				"Processing will continue on %s but original file might be missing.",
+				originalFilepath, err, tmpFilepath))
+		} else {
+			newFile.Close()
+		}
+	}
+
+	ctx := context.Background()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(p.Timeout))
+		defer cancel()
+	}
+
+	// p.TsharkArgs is shared across every concurrent processFile goroutine,
+	// so it must be cloned before appending: append can otherwise reuse
+	// spare capacity in the shared backing array, letting two goroutines
+	// race on it (or one file's "-r" silently end up in another's args).
+	tsharkCmdArgs := append(slices.Clone(p.TsharkArgs), tsharkFormatArgs(p.TsharkFormat)...)
+	tsharkCmdArgs = append(tsharkCmdArgs, "-r", tmpFilepath)
+	cmd := exec.CommandContext(ctx, p.TsharkPath, tsharkCmdArgs...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		acc.AddError(fmt.Errorf("failed to open stdout pipe for `tshark` on %s: %w", tmpFilepath, err))
+		os.Remove(tmpFilepath)
+		return
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		acc.AddError(fmt.Errorf("failed to start `tshark` for %s: %w", tmpFilepath, err))
+		os.Remove(tmpFilepath)
+		return
+	}
+
+	// Streaming straight from the pipe, instead of buffering cmd.Output(),
+	// means records reach the accumulator as tshark produces them and a
+	// multi-GB capture never needs to fit in memory at once.
+	var reader io.Reader = stdout
+	limited := &maxBytesReader{r: stdout, limit: p.MaxOutputBytes, kill: cmd.Process.Kill}
+	if p.MaxOutputBytes > 0 {
+		reader = limited
+	}
+
+	decodeErr := s.decoder.decode(reader, func(rec *packetRecord) {
+		rec.addTo(acc)
+	}, func(err error) {
+		acc.AddError(fmt.Errorf("%s: %w", tmpFilepath, err))
+	})
+
+	waitErr := cmd.Wait()
+
+	switch {
+	case limited.exceeded:
+		acc.AddError(fmt.Errorf("`tshark` output for %s exceeded max_output_bytes (%d), killed",
+			tmpFilepath, p.MaxOutputBytes))
+	case ctx.Err() == context.DeadlineExceeded:
+		acc.AddError(fmt.Errorf("`tshark` for %s timed out after %s, killed",
+			tmpFilepath, time.Duration(p.Timeout)))
+	case waitErr != nil:
+		acc.AddError(fmt.Errorf("`tshark` for %s exited with error: %w\nStderr: %s",
+			tmpFilepath, waitErr, stderr.String()))
+	case decodeErr != nil:
+		acc.AddError(fmt.Errorf("failed to decode tshark output for %s: %w", tmpFilepath, decodeErr))
+	}
+
+	s.finishFile(acc, tmpFilepath)
+}
+
+// maxBytesReader stops reading and kills the owning process once more than
+// limit bytes have been read, so a malformed or unexpectedly huge capture
+// cannot grow tshark's output without bound. limit <= 0 disables the guard
+// (the caller simply does not wrap the reader with it in that case).
+type maxBytesReader struct {
+	r     io.Reader
+	limit int64
+	kill  func() error
+
+	n        int64
+	exceeded bool
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	if m.n > m.limit {
+		m.exceeded = true
+		m.kill()
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// finishFile disposes of the staged copy of a processed file according to
+// `post_process`: "delete" (the original, default behavior), "keep" (leave
+// it at tmpFilepath for inspection), or "move:<dir>" (archive it).
+func (s *fileSource) finishFile(acc telegraf.Accumulator, tmpFilepath string) {
+	action, moveDir, err := parsePostProcess(s.plugin.PostProcess)
+	if err != nil {
+		// Already validated in Init; should not happen.
+		acc.AddError(err)
+		return
+	}
+
+	switch action {
+	case "keep":
+		return
+	case "move":
+		if err := os.MkdirAll(moveDir, 0755); err != nil {
+			acc.AddError(fmt.Errorf("failed to create `post_process` destination %s: %w", moveDir, err))
+			return
+		}
+		dest := filepath.Join(moveDir, filepath.Base(tmpFilepath))
+		if err := os.Rename(tmpFilepath, dest); err != nil {
+			acc.AddError(fmt.Errorf("failed to move processed PCAP file %s to %s: %w",
+				tmpFilepath, dest, err))
+		}
+	default: // "delete"
+		if err := os.Remove(tmpFilepath); err != nil {
+			acc.AddError(fmt.Errorf("failed to remove processing PCAP file %s: %w",
+				tmpFilepath, err))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package pcap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestResolveFilesOnlyMarksStaticFilesForRecreate(t *testing.T) {
+	dir := t.TempDir()
+
+	listPath := filepath.Join(dir, "pcap.list")
+	require.NoError(t, os.WriteFile(listPath, []byte("/from/files_from.pcap\n"), 0644))
+
+	p := &PCAP{
+		Files:     []string{"/static/capture.pcap"},
+		FilesFrom: listPath,
+	}
+	s := &fileSource{
+		plugin:  p,
+		watcher: newDirWatcher(p),
+	}
+	s.watcher.ready = []string{"/watched/rotated.pcap"}
+
+	var acc testutil.Accumulator
+	files := s.resolveFiles(&acc)
+
+	require.Len(t, files, 3)
+	byPath := make(map[string]bool, len(files))
+	for _, f := range files {
+		byPath[f.path] = f.recreate
+	}
+
+	require.True(t, byPath["/static/capture.pcap"], "a static `files` entry should be recreated after processing")
+	require.False(t, byPath["/from/files_from.pcap"], "a `files_from` entry must not be recreated, or it would be reprocessed forever")
+	require.False(t, byPath["/watched/rotated.pcap"], "a watch_dirs entry must not be recreated, or it would be reprocessed forever")
+}
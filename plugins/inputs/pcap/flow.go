@@ -0,0 +1,278 @@
+package pcap
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/influxdata/telegraf"
+)
+
+// flowKey canonicalizes a bidirectional 5-tuple (plus VLAN) so that packets
+// seen in either direction land in the same flow entry.
+type flowKey struct {
+	loAddr, hiAddr string
+	loPort, hiPort uint16
+	proto          string
+	vlan           uint16
+}
+
+// flowEntry accumulates counters for one bidirectional flow between two
+// flushes. srcIP/srcPort/dstIP/dstPort record the orientation of the first
+// packet seen for the flow, which is also what forward/reverse counters are
+// relative to.
+type flowEntry struct {
+	key  flowKey
+	elem *list.Element
+
+	srcIP, dstIP     string
+	srcPort, dstPort uint16
+	vlan             uint16
+
+	packets, bytes           uint64
+	fwdPackets, fwdBytes     uint64
+	revPackets, revBytes     uint64
+	tcpSYNCount, tcpRSTCount uint64
+	retransmits              uint64
+
+	fwdLastSeq, revLastSeq uint32
+	fwdSeqSeen, revSeqSeen bool
+
+	start, lastSeen time.Time
+}
+
+// flowTable groups packets into bidirectional flows, keyed by canonicalized
+// 5-tuple, and evicts them once idle or active for too long - the same
+// idle/active timeout scheme NetFlow exporters use - or once the table grows
+// past maxFlows, in which case the least recently touched flow is evicted.
+type flowTable struct {
+	mu    sync.Mutex
+	flows map[flowKey]*flowEntry
+	lru   *list.List // front = most recently touched
+
+	measurement   string
+	idleTimeout   time.Duration
+	activeTimeout time.Duration
+	maxFlows      int
+}
+
+func newFlowTable(measurement string, idleTimeout, activeTimeout time.Duration, maxFlows int) *flowTable {
+	return &flowTable{
+		flows:         make(map[flowKey]*flowEntry),
+		lru:           list.New(),
+		measurement:   measurement,
+		idleTimeout:   idleTimeout,
+		activeTimeout: activeTimeout,
+		maxFlows:      maxFlows,
+	}
+}
+
+// observe folds one packet into its flow, creating the flow if this is the
+// first packet seen for the tuple. If that creation pushes the table past
+// flow_max_flows, the least recently touched flow is evicted and flushed to
+// acc immediately, the same as a flow aged out by flush/flushAll.
+func (t *flowTable) observe(proto, srcIP, dstIP string, srcPort, dstPort, vlan uint16, length int, syn, rst bool, seq uint32, seqValid bool, now time.Time, acc telegraf.Accumulator) {
+	forward, key := canonicalFlowKey(proto, srcIP, dstIP, srcPort, dstPort, vlan)
+
+	t.mu.Lock()
+
+	var evicted *flowEntry
+	entry, ok := t.flows[key]
+	if !ok {
+		entry = &flowEntry{
+			key:     key,
+			srcIP:   srcIP,
+			dstIP:   dstIP,
+			srcPort: srcPort,
+			dstPort: dstPort,
+			vlan:    vlan,
+			start:   now,
+		}
+		entry.elem = t.lru.PushFront(entry)
+		t.flows[key] = entry
+
+		if t.maxFlows > 0 && len(t.flows) > t.maxFlows {
+			evicted = t.evictOldestLocked(nil)
+		}
+	} else {
+		t.lru.MoveToFront(entry.elem)
+	}
+
+	entry.packets++
+	entry.bytes += uint64(length)
+	entry.lastSeen = now
+
+	if syn {
+		entry.tcpSYNCount++
+	}
+	if rst {
+		entry.tcpRSTCount++
+	}
+
+	if forward {
+		entry.fwdPackets++
+		entry.fwdBytes += uint64(length)
+		if seqValid {
+			if entry.fwdSeqSeen && seq <= entry.fwdLastSeq {
+				entry.retransmits++
+			}
+			entry.fwdLastSeq = seq
+			entry.fwdSeqSeen = true
+		}
+	} else {
+		entry.revPackets++
+		entry.revBytes += uint64(length)
+		if seqValid {
+			if entry.revSeqSeen && seq <= entry.revLastSeq {
+				entry.retransmits++
+			}
+			entry.revLastSeq = seq
+			entry.revSeqSeen = true
+		}
+	}
+
+	t.mu.Unlock()
+
+	if evicted != nil {
+		evicted.addTo(acc, t.measurement)
+	}
+}
+
+// flush evicts and emits every flow that has exceeded its idle or active
+// timeout as of now. It is called on every Gather, mirroring how a NetFlow
+// exporter periodically ages out its flow cache.
+func (t *flowTable) flush(acc telegraf.Accumulator, now time.Time) {
+	t.mu.Lock()
+	var expired []*flowEntry
+	for _, entry := range t.flows {
+		if now.Sub(entry.lastSeen) >= t.idleTimeout || now.Sub(entry.start) >= t.activeTimeout {
+			expired = append(expired, entry)
+		}
+	}
+	for _, entry := range expired {
+		t.evictLocked(entry)
+	}
+	t.mu.Unlock()
+
+	for _, entry := range expired {
+		entry.addTo(acc, t.measurement)
+	}
+}
+
+// flushAll unconditionally evicts and emits every flow still in the table,
+// used when the plugin is shutting down so in-flight flows are not lost.
+func (t *flowTable) flushAll(acc telegraf.Accumulator) {
+	t.mu.Lock()
+	entries := make([]*flowEntry, 0, len(t.flows))
+	for _, entry := range t.flows {
+		entries = append(entries, entry)
+	}
+	for _, entry := range entries {
+		t.evictLocked(entry)
+	}
+	t.mu.Unlock()
+
+	for _, entry := range entries {
+		entry.addTo(acc, t.measurement)
+	}
+}
+
+// evictOldestLocked drops the least recently touched flow to make room for a
+// new one and returns it so the caller can flush it once unlocked. If skip is
+// non-nil, it is never the eviction target (it is the flow currently being
+// inserted).
+func (t *flowTable) evictOldestLocked(skip *flowEntry) *flowEntry {
+	for e := t.lru.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(*flowEntry)
+		if entry == skip {
+			continue
+		}
+		t.evictLocked(entry)
+		return entry
+	}
+	return nil
+}
+
+func (t *flowTable) evictLocked(entry *flowEntry) {
+	delete(t.flows, entry.key)
+	t.lru.Remove(entry.elem)
+}
+
+func (e *flowEntry) addTo(acc telegraf.Accumulator, measurement string) {
+	tags := map[string]string{
+		"src_ip":   e.srcIP,
+		"dst_ip":   e.dstIP,
+		"src_port": strconv.Itoa(int(e.srcPort)),
+		"dst_port": strconv.Itoa(int(e.dstPort)),
+		"proto":    e.key.proto,
+	}
+	if e.vlan != 0 {
+		tags["vlan"] = strconv.Itoa(int(e.vlan))
+	}
+
+	fields := map[string]interface{}{
+		"packets":       e.packets,
+		"bytes":         e.bytes,
+		"duration_ns":   e.lastSeen.Sub(e.start).Nanoseconds(),
+		"start":         e.start.UnixNano(),
+		"end":           e.lastSeen.UnixNano(),
+		"tcp_syn_count": e.tcpSYNCount,
+		"tcp_rst_count": e.tcpRSTCount,
+		"retransmits":   e.retransmits,
+		"fwd_packets":   e.fwdPackets,
+		"fwd_bytes":     e.fwdBytes,
+		"rev_packets":   e.revPackets,
+		"rev_bytes":     e.revBytes,
+	}
+
+	acc.AddFields(measurement, fields, tags, e.lastSeen)
+}
+
+// canonicalFlowKey builds the bidirectional key for a 5-tuple and reports
+// whether (srcIP, srcPort) is the "forward" direction for that key, i.e.
+// whichever side sorts first lexicographically becomes "lo".
+func canonicalFlowKey(proto, srcIP, dstIP string, srcPort, dstPort, vlan uint16) (forward bool, key flowKey) {
+	src := srcIP + ":" + strconv.Itoa(int(srcPort))
+	dst := dstIP + ":" + strconv.Itoa(int(dstPort))
+
+	if src <= dst {
+		return true, flowKey{loAddr: srcIP, loPort: srcPort, hiAddr: dstIP, hiPort: dstPort, proto: proto, vlan: vlan}
+	}
+	return false, flowKey{loAddr: dstIP, loPort: dstPort, hiAddr: srcIP, hiPort: srcPort, proto: proto, vlan: vlan}
+}
+
+// decodeFlowPacket extracts the fields observe needs directly from a decoded
+// packet. It returns ok=false for packets with no recognizable network-layer
+// 5-tuple (e.g. ARP), which are not part of any flow.
+func decodeFlowPacket(linkType layers.LinkType, data []byte, ci gopacket.CaptureInfo) (ok bool, proto, srcIP, dstIP string, srcPort, dstPort, vlan uint16, length int, syn, rst bool, seq uint32, seqValid bool) {
+	packet := gopacket.NewPacket(data, linkType, gopacket.Lazy)
+	length = ci.Length
+
+	if dot1q, found := packet.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q); found {
+		vlan = dot1q.VLANIdentifier
+	}
+
+	switch {
+	case packet.Layer(layers.LayerTypeIPv4) != nil:
+		ip := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		srcIP, dstIP, proto = ip.SrcIP.String(), ip.DstIP.String(), ip.Protocol.String()
+	case packet.Layer(layers.LayerTypeIPv6) != nil:
+		ip := packet.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		srcIP, dstIP, proto = ip.SrcIP.String(), ip.DstIP.String(), ip.NextHeader.String()
+	default:
+		return false, "", "", "", 0, 0, 0, length, false, false, 0, false
+	}
+
+	if tcp, found := packet.Layer(layers.LayerTypeTCP).(*layers.TCP); found {
+		srcPort, dstPort = uint16(tcp.SrcPort), uint16(tcp.DstPort)
+		syn, rst = tcp.SYN, tcp.RST
+		seq, seqValid = tcp.Seq, true
+	} else if udp, found := packet.Layer(layers.LayerTypeUDP).(*layers.UDP); found {
+		srcPort, dstPort = uint16(udp.SrcPort), uint16(udp.DstPort)
+	}
+
+	return true, proto, srcIP, dstIP, srcPort, dstPort, vlan, length, syn, rst, seq, seqValid
+}
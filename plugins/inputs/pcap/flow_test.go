@@ -0,0 +1,73 @@
+package pcap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestCanonicalFlowKeySymmetric(t *testing.T) {
+	fwd, key := canonicalFlowKey("tcp", "10.0.0.1", "10.0.0.2", 1234, 443, 0)
+	rev, reverseKey := canonicalFlowKey("tcp", "10.0.0.2", "10.0.0.1", 443, 1234, 0)
+	require.True(t, fwd)
+	require.False(t, rev)
+	require.Equal(t, key, reverseKey)
+
+	_, other := canonicalFlowKey("tcp", "10.0.0.1", "10.0.0.3", 1234, 443, 0)
+	require.NotEqual(t, key, other)
+}
+
+func TestFlowTableObserveAggregatesBothDirections(t *testing.T) {
+	now := time.Now()
+	ft := newFlowTable("pcap_flow", 15*time.Second, 300*time.Second, 0)
+
+	var acc testutil.Accumulator
+	ft.observe("tcp", "10.0.0.1", "10.0.0.2", 1234, 443, 0, 60, true, false, 1, true, now, &acc)
+	ft.observe("tcp", "10.0.0.2", "10.0.0.1", 443, 1234, 0, 120, false, false, 1, true, now.Add(time.Millisecond), &acc)
+
+	ft.flushAll(&acc)
+
+	require.Len(t, acc.Metrics, 1)
+	m := acc.Metrics[0]
+	require.Equal(t, "pcap_flow", m.Measurement)
+	require.Equal(t, "10.0.0.1", m.Tags["src_ip"])
+	require.Equal(t, "10.0.0.2", m.Tags["dst_ip"])
+	require.EqualValues(t, 2, m.Fields["packets"])
+	require.EqualValues(t, 180, m.Fields["bytes"])
+	require.EqualValues(t, 1, m.Fields["tcp_syn_count"])
+	require.EqualValues(t, 60, m.Fields["fwd_bytes"])
+	require.EqualValues(t, 120, m.Fields["rev_bytes"])
+}
+
+func TestFlowTableFlushOnlyEvictsIdleFlows(t *testing.T) {
+	now := time.Now()
+	ft := newFlowTable("pcap_flow", 15*time.Second, 300*time.Second, 0)
+
+	var acc testutil.Accumulator
+	ft.observe("udp", "10.0.0.1", "10.0.0.2", 53, 5353, 0, 40, false, false, 0, false, now, &acc)
+
+	ft.flush(&acc, now.Add(time.Second))
+	require.Empty(t, acc.Metrics, "flow is still active, should not be flushed yet")
+
+	ft.flush(&acc, now.Add(20*time.Second))
+	require.Len(t, acc.Metrics, 1, "flow has been idle past flow_idle_timeout, should be flushed")
+}
+
+func TestFlowTableMaxFlowsEvictsOldest(t *testing.T) {
+	now := time.Now()
+	ft := newFlowTable("pcap_flow", 15*time.Second, 300*time.Second, 1)
+
+	var acc testutil.Accumulator
+	ft.observe("udp", "10.0.0.1", "10.0.0.2", 1, 2, 0, 10, false, false, 0, false, now, &acc)
+	ft.observe("udp", "10.0.0.3", "10.0.0.4", 1, 2, 0, 10, false, false, 0, false, now.Add(time.Millisecond), &acc)
+
+	require.Len(t, acc.Metrics, 1, "flow_max_flows=1 should have flushed the evicted first flow immediately, not dropped it")
+	require.Equal(t, "10.0.0.1", acc.Metrics[0].Tags["src_ip"], "the first (oldest) flow is the one that gets evicted")
+
+	ft.flushAll(&acc)
+	require.Len(t, acc.Metrics, 2, "the second flow is still in the table and should be flushed by flushAll")
+	require.Equal(t, "10.0.0.3", acc.Metrics[1].Tags["src_ip"])
+}
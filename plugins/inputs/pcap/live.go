@@ -0,0 +1,196 @@
+package pcap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/influxdata/telegraf"
+)
+
+// packetSource abstracts the underlying live-capture handle so liveSource
+// works the same whether packets arrive via libpcap or the Linux-only
+// AF_PACKET backend.
+type packetSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	Close()
+}
+
+// bpfSettable is implemented by packetSource backends that support
+// installing a kernel-side BPF filter.
+type bpfSettable interface {
+	SetBPFFilter(filter string) error
+}
+
+// captureReadTimeout bounds how long ReadPacketData blocks waiting for the
+// next packet. Both capture backends are opened with this as their poll/read
+// timeout so that, on a quiet interface, capture's select on s.done is
+// re-checked at least this often instead of blocking forever - otherwise
+// stopCapture could hang indefinitely waiting for a read that never returns.
+const captureReadTimeout = time.Second
+
+// errCaptureTimeout is what each backend's ReadPacketData translates its own
+// timeout error into, so capture has one sentinel to check regardless of
+// which backend is in use.
+var errCaptureTimeout = errors.New("pcap: read timeout")
+
+// liveSource captures packets directly from one or more interfaces using
+// gopacket, instead of waiting for tshark to finish writing a file. PCAP
+// drives it from its telegraf.ServiceInput Start/Stop, since packets arrive
+// asynchronously rather than on a polled interval.
+type liveSource struct {
+	plugin *PCAP
+
+	acc    telegraf.Accumulator
+	wg     sync.WaitGroup
+	done   chan struct{}
+	wanted map[string]bool
+	flows  *flowTable
+}
+
+func newLiveSource(p *PCAP) *liveSource {
+	return &liveSource{plugin: p}
+}
+
+func (s *liveSource) start(acc telegraf.Accumulator) error {
+	p := s.plugin
+	if len(p.Interfaces) == 0 {
+		return errors.New("`interfaces` must be set for `mode = \"live\"`")
+	}
+
+	s.acc = acc
+	s.done = make(chan struct{})
+	s.wanted = make(map[string]bool, len(p.Layers))
+	for _, l := range p.Layers {
+		s.wanted[strings.ToLower(l)] = true
+	}
+
+	if p.Flow {
+		s.flows = newFlowTable(
+			p.FlowMeasurement,
+			time.Duration(p.FlowIdleTimeout),
+			time.Duration(p.FlowActiveTimeout),
+			p.FlowMaxFlows,
+		)
+	}
+
+	handles := make([]struct {
+		iface  string
+		handle packetSource
+	}, 0, len(p.Interfaces))
+
+	for _, iface := range p.Interfaces {
+		handle, err := s.openHandle(iface)
+		if err != nil {
+			for _, h := range handles {
+				h.handle.Close()
+			}
+			return fmt.Errorf("failed to open interface %q: %w", iface, err)
+		}
+
+		if p.BPFFilter != "" {
+			setter, ok := handle.(bpfSettable)
+			if !ok {
+				handle.Close()
+				for _, h := range handles {
+					h.handle.Close()
+				}
+				return fmt.Errorf("capture backend for interface %q does not support `bpf_filter`", iface)
+			}
+			if err := setter.SetBPFFilter(p.BPFFilter); err != nil {
+				handle.Close()
+				for _, h := range handles {
+					h.handle.Close()
+				}
+				return fmt.Errorf("failed to set BPF filter on interface %q: %w", iface, err)
+			}
+		}
+
+		handles = append(handles, struct {
+			iface  string
+			handle packetSource
+		}{iface, handle})
+	}
+
+	for _, h := range handles {
+		s.wg.Add(1)
+		go s.capture(h.iface, h.handle)
+	}
+
+	return nil
+}
+
+func (s *liveSource) openHandle(iface string) (packetSource, error) {
+	p := s.plugin
+	switch strings.ToLower(p.CaptureBackend) {
+	case "", "pcap":
+		return openPcapHandle(iface, p.SnapLen, p.Promiscuous)
+	case "afpacket":
+		return openAfpacketHandle(iface, p.SnapLen)
+	default:
+		return nil, fmt.Errorf("unknown `capture_backend` %q", p.CaptureBackend)
+	}
+}
+
+func (s *liveSource) capture(iface string, handle packetSource) {
+	defer s.wg.Done()
+	defer handle.Close()
+
+	linkType := handle.LinkType()
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		data, ci, err := handle.ReadPacketData()
+		if err != nil {
+			if errors.Is(err, errCaptureTimeout) {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			s.acc.AddError(fmt.Errorf("failed to read packet on %s: %w", iface, err))
+			continue
+		}
+
+		if s.flows != nil {
+			if ok, proto, srcIP, dstIP, srcPort, dstPort, vlan, length, syn, rst, seq, seqValid := decodeFlowPacket(linkType, data, ci); ok {
+				s.flows.observe(proto, srcIP, dstIP, srcPort, dstPort, vlan, length, syn, rst, seq, seqValid, time.Now(), s.acc)
+			}
+			continue
+		}
+
+		rec := decodePacket(s.plugin.MeasurementName, iface, linkType, data, ci, s.wanted)
+		rec.addTo(s.acc)
+	}
+}
+
+// flushFlows ages out and emits any flows that have exceeded their idle or
+// active timeout. It is a no-op when `flow` mode is not enabled.
+func (s *liveSource) flushFlows(acc telegraf.Accumulator) {
+	if s.flows == nil {
+		return
+	}
+	s.flows.flush(acc, time.Now())
+}
+
+func (s *liveSource) stopCapture() {
+	if s.done == nil {
+		return
+	}
+	close(s.done)
+	s.wg.Wait()
+
+	if s.flows != nil {
+		s.flows.flushAll(s.acc)
+	}
+}
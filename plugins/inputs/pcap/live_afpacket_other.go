@@ -0,0 +1,9 @@
+//go:build !linux
+
+package pcap
+
+import "fmt"
+
+func openAfpacketHandle(_ string, _ int) (packetSource, error) {
+	return nil, fmt.Errorf("`capture_backend = \"afpacket\"` is only supported on linux")
+}
@@ -0,0 +1,41 @@
+package pcap
+
+import (
+	"errors"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// pcapHandle wraps *pcap.Handle only to translate its read-timeout error
+// into errCaptureTimeout; LinkType, Close, and SetBPFFilter are promoted
+// straight from the embedded handle.
+type pcapHandle struct {
+	*pcap.Handle
+}
+
+// openPcapHandle opens a live capture handle on iface using libpcap (or
+// Npcap on Windows). This is the default, cross-platform capture backend.
+func openPcapHandle(iface string, snaplen int, promiscuous bool) (packetSource, error) {
+	if snaplen <= 0 {
+		snaplen = 262144
+	}
+
+	handle, err := pcap.OpenLive(iface, int32(snaplen), promiscuous, captureReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pcapHandle{Handle: handle}, nil
+}
+
+func (h *pcapHandle) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := h.Handle.ReadPacketData()
+	if errors.Is(err, pcap.NextErrorTimeoutExpired) {
+		return nil, ci, errCaptureTimeout
+	}
+	return data, ci, err
+}
+
+var _ packetSource = (*pcapHandle)(nil)
+var _ bpfSettable = (*pcapHandle)(nil)
@@ -2,40 +2,80 @@
 package pcap
 
 import (
-	"bytes"
 	_ "embed"
-	"encoding/csv"
 	"fmt"
-	"io"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"slices"
-	"strconv"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
 	"github.com/influxdata/telegraf/plugins/inputs"
 )
 
 //go:embed sample.conf
 var sampleCfg string
 
+var _ telegraf.ServiceInput = (*PCAP)(nil)
+
+// PCAP is an input plugin that turns packet captures into metrics, either by
+// shelling out to tshark against finished pcap files (`mode = "file"`, the
+// default) or by capturing packets directly off an interface in-process via
+// gopacket (`mode = "live"`).
 type PCAP struct {
+	Mode string `toml:"mode"`
+
+	// file mode
 	Files []string `toml:"files"`
 
+	// files_from and watch_dirs are additional, re-evaluated-each-interval
+	// ways to discover files to process, alongside the static Files list.
+	FilesFrom                string          `toml:"files_from"`
+	WatchDirs                []string        `toml:"watch_dirs"`
+	Pattern                  string          `toml:"pattern"`
+	WatchStableCheckInterval config.Duration `toml:"watch_stable_check_interval"`
+	PostProcess              string          `toml:"post_process"`
+
 	CSVColumnNames     []string `toml:"csv_column_names"`
 	CSVColumnTypes     []string `toml:"csv_column_types"`
-	CSVTagColumns      []string `toml:"csv_tag_columns"`
+	CSVTagColumns      []string `toml:"csv_tag_columns" deprecated:"1.36.0;use 'tag_fields' instead"`
+	TagFields          []string `toml:"tag_fields"`
 	CSVTimestampColumn string   `toml:"csv_timestamp_column"`
 	CSVTimestampFormat string   `toml:"csv_timestamp_format"`
 	CSVMeasurementName string   `toml:"csv_measurement_name"`
 
-	TsharkPath string   `toml:"tshark_path"`
-	TsharkArgs []string `toml:"tshark_args"`
+	TsharkPath   string   `toml:"tshark_path"`
+	TsharkArgs   []string `toml:"tshark_args"`
+	TsharkFormat string   `toml:"tshark_format"`
 
 	TmpDir string `toml:"tmp_dir"`
+
+	// MaxConcurrentFiles bounds how many files are run through tshark at
+	// once; MaxOutputBytes and Timeout bound each individual tshark run so
+	// one malformed capture cannot stall or OOM the input.
+	MaxConcurrentFiles int             `toml:"max_concurrent_files"`
+	MaxOutputBytes     int64           `toml:"max_output_bytes"`
+	Timeout            config.Duration `toml:"timeout"`
+
+	// live mode
+	Interfaces      []string `toml:"interfaces"`
+	CaptureBackend  string   `toml:"capture_backend"`
+	BPFFilter       string   `toml:"bpf_filter"`
+	SnapLen         int      `toml:"snaplen"`
+	Promiscuous     bool     `toml:"promiscuous"`
+	Layers          []string `toml:"layers"`
+	MeasurementName string   `toml:"measurement_name"`
+
+	// flow mode: aggregates live-captured packets into per-connection
+	// metrics instead of emitting one metric per packet
+	Flow              bool            `toml:"flow"`
+	FlowIdleTimeout   config.Duration `toml:"flow_idle_timeout"`
+	FlowActiveTimeout config.Duration `toml:"flow_active_timeout"`
+	FlowMaxFlows      int             `toml:"flow_max_flows"`
+	FlowMeasurement   string          `toml:"flow_measurement_name"`
+
+	fileSrc *fileSource
+	liveSrc *liveSource
 }
 
 // SampleConfig returns the default configuration of the Input.
@@ -45,154 +85,115 @@ func (*PCAP) SampleConfig() string {
 
 // Description returns a one-sentence description on the Input.
 func (p *PCAP) Description() string {
-	return "A Telegraf input plugin to process PCAP files using `tshark`."
+	return "A Telegraf input plugin to process PCAP files using `tshark`, or to capture packets live using gopacket."
 }
 
-// Gather takes in an accumulator and adds the metrics that the Input gathers.
-// This is called every "interval".
-func (p *PCAP) Gather(acc telegraf.Accumulator) error {
-	if p.TsharkPath == "" {
-		return fmt.Errorf("`tshark_path` is not configured")
-	}
-
-	tmpDir := p.TmpDir
-	if tmpDir == "" {
-		tmpDir = os.TempDir()
-	}
-
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		return fmt.Errorf("failed to created temporary directory %s: %w", tmpDir, err)
-	}
-
-	for _, originalFilepath := range p.Files {
-		tmpFilename := fmt.Sprintf("%s.pcap.processing", filepath.Base(originalFilepath))
-		tmpFilepath := filepath.Join(tmpDir, tmpFilename)
-
-		err := os.Rename(originalFilepath, tmpFilepath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				acc.AddError(fmt.Errorf("original PCAP file %s does not exist, skipping. It might have been rotated or cleand up.",
-					originalFilepath))
-			} else {
-				acc.AddError(fmt.Errorf("failed to rename original PCAP file %s to %s: %w",
-					originalFilepath, tmpFilepath, err))
-			}
-			continue
+// Init sets up whichever capture source the configured mode calls for.
+func (p *PCAP) Init() error {
+	switch p.Mode {
+	case "", "file":
+		p.Mode = "file"
+		if len(p.TagFields) == 0 {
+			p.TagFields = p.CSVTagColumns
 		}
-
-		newFile, err := os.Create(originalFilepath)
-		if err != nil {
-			acc.AddError(fmt.Errorf("failed to create new empty PCAP file %s after renaming: %w. "+
-				"Processing will continue on %s but original file might be missing.",
-				originalFilepath, err, tmpFilepath))
-		} else {
-			newFile.Close()
+		if p.Pattern == "" {
+			p.Pattern = "*.pcap"
 		}
-
-		tsharkCmdArgs := append(p.TsharkArgs, "-r", tmpFilepath)
-		cmd := exec.Command(p.TsharkPath, tsharkCmdArgs...)
-
-		output, err := cmd.Output()
+		if _, _, err := parsePostProcess(p.PostProcess); err != nil {
+			return err
+		}
+		if p.MaxConcurrentFiles <= 0 {
+			p.MaxConcurrentFiles = runtime.GOMAXPROCS(0)
+		}
+		decoder, err := newTsharkDecoder(p)
 		if err != nil {
-			os.Remove(tmpFilepath)
-			acc.AddError(fmt.Errorf("failed to execute `tshark` for %s: %w\nOutput: %s",
-				tmpFilepath, err, output))
-			continue
+			return err
 		}
-
-		reader := bytes.NewReader(output)
-		csvReader := csv.NewReader(reader)
-		csvReader.Comma = ','
-
-		// Cache which column names are tags
-		numExpectedEntries := len(p.CSVColumnNames)
-		tagMap := make([]bool, numExpectedEntries)
-		for i, name := range p.CSVColumnNames {
-			tagMap[i] = slices.Contains(p.CSVTagColumns, name)
+		p.fileSrc = newFileSource(p, decoder)
+		if len(p.WatchDirs) > 0 {
+			p.fileSrc.watcher = newDirWatcher(p)
 		}
-
-		for lno := 0; ; lno++ {
-			record, err := csvReader.Read()
-			if err == io.EOF {
-				break
+	case "live":
+		if p.MeasurementName == "" {
+			p.MeasurementName = "pcap"
+		}
+		if p.Flow {
+			if p.FlowMeasurement == "" {
+				p.FlowMeasurement = "pcap_flow"
 			}
-			if err != nil {
-				acc.AddError(fmt.Errorf("failed to read CSV record from `tshark` output for %s at line %d: %w",
-					tmpFilepath, lno, err))
-				continue
+			if p.FlowIdleTimeout == 0 {
+				p.FlowIdleTimeout = config.Duration(15 * time.Second)
 			}
-
-			// Verify that the record has the expected number of entries
-			numGotEntries := len(record)
-			if numGotEntries != numExpectedEntries {
-				acc.AddError(fmt.Errorf("CSV record at line %d has %d entries, but expected %d based on columns for %s. Skipping...",
-					numGotEntries, numExpectedEntries, tmpFilepath))
-				continue
+			if p.FlowActiveTimeout == 0 {
+				p.FlowActiveTimeout = config.Duration(300 * time.Second)
 			}
+		}
+		p.liveSrc = newLiveSource(p)
+	default:
+		return fmt.Errorf("unknown `mode` %q, must be `file` or `live`", p.Mode)
+	}
+	return nil
+}
 
-			tags := make(map[string]string)
-			fields := make(map[string]interface{})
-			var timestamp time.Time = time.Now()
-
-			for i, col := range p.CSVColumnNames {
-				val := record[i]
-
-				// Check if it is a tag column
-				isTag := tagMap[i]
-				if isTag {
-					tags[col] = val
-					continue
-				}
-
-				// Check if it is a timestamp column
-				if col == p.CSVTimestampColumn {
-					parsedTime, err := time.Parse(p.CSVTimestampFormat, val)
-					if err != nil {
-						acc.AddError(fmt.Errorf("failed to parse timestap '%s' with format '%s' for column '%s': %w",
-							val, p.CSVTimestampFormat, col, err))
-					} else {
-						timestamp = parsedTime
-					}
-					continue
-				}
-
-				// Otherwise, it is a field; parse by type
-				colType := p.CSVColumnTypes[i]
-				switch strings.ToLower(colType) {
-				case "int":
-					if parsedVal, err := strconv.Atoi(val); err != nil {
-						acc.AddError(fmt.Errorf("failed to parse int for column '%s' value '%s': %w",
-							col, val, err))
-					} else {
-						fields[col] = parsedVal
-					}
-				case "float":
-					if parsedVal, err := strconv.ParseFloat(val, 64); err != nil {
-						acc.AddError(fmt.Errorf("failed to parse float64 for column '%s' value '%s': %w",
-							col, val, err))
-					} else {
-						fields[col] = parsedVal
-					}
-				case "bool":
-					if parsedVal, err := strconv.ParseBool(val); err != nil {
-						acc.AddError(fmt.Errorf("failed to parse bool for column '%s' value '%s': %w",
-							col, val, err))
-					} else {
-						fields[col] = parsedVal
-					}
-				default: // "string" or unknown type
-					fields[col] = val
-				}
-			}
-			acc.AddFields(p.CSVMeasurementName, fields, tags, timestamp)
+// Start begins live packet capture and, in file mode, any configured
+// directory watcher. Capture-by-polling in file mode still happens on each
+// Gather call.
+func (p *PCAP) Start(acc telegraf.Accumulator) error {
+	if p.fileSrc != nil && p.fileSrc.watcher != nil {
+		if err := p.fileSrc.watcher.start(); err != nil {
+			return fmt.Errorf("failed to start `watch_dirs` watcher: %w", err)
 		}
+	}
+	if p.liveSrc == nil {
+		return nil
+	}
+	return p.liveSrc.start(acc)
+}
 
-		if err := os.Remove(tmpFilepath); err != nil {
-			acc.AddError(fmt.Errorf("failed to remove processing PCAP file %s: %w",
-				tmpFilepath, err))
+// Stop tears down any live capture handles and directory watchers started by
+// Start, flushing any flows still sitting in the flow table so in-flight
+// connections are not silently dropped.
+func (p *PCAP) Stop() {
+	if p.fileSrc != nil && p.fileSrc.watcher != nil {
+		p.fileSrc.watcher.stop()
+	}
+	if p.liveSrc != nil {
+		p.liveSrc.stopCapture()
+	}
+}
+
+// parsePostProcess parses the `post_process` option into an action
+// ("delete", "keep", or "move") and, for "move", the destination directory.
+func parsePostProcess(postProcess string) (action, moveDir string, err error) {
+	if postProcess == "" {
+		return "delete", "", nil
+	}
+	if dir, ok := strings.CutPrefix(postProcess, "move:"); ok {
+		if dir == "" {
+			return "", "", fmt.Errorf("`post_process = \"move:<dir>\"` requires a non-empty directory")
 		}
+		return "move", dir, nil
+	}
+	switch postProcess {
+	case "delete", "keep":
+		return postProcess, "", nil
+	default:
+		return "", "", fmt.Errorf("unknown `post_process` %q, must be `delete`, `keep`, or `move:<dir>`", postProcess)
 	}
+}
 
+// Gather takes in an accumulator and adds the metrics that the Input gathers.
+// This is called every "interval". In live mode, per-packet metrics are
+// instead pushed asynchronously by the goroutines started in Start; Gather
+// is still used there to age out and emit completed flows when `flow` mode
+// is enabled.
+func (p *PCAP) Gather(acc telegraf.Accumulator) error {
+	if p.fileSrc != nil {
+		return p.fileSrc.gather(acc)
+	}
+	if p.liveSrc != nil {
+		p.liveSrc.flushFlows(acc)
+	}
 	return nil
 }
 
@@ -200,6 +201,9 @@ func (p *PCAP) Gather(acc telegraf.Accumulator) error {
 // This function is automatically called when the package is initialised.
 func init() {
 	inputs.Add("pcap", func() telegraf.Input {
-		return &PCAP{}
+		return &PCAP{
+			Mode:    "file",
+			SnapLen: 262144,
+		}
 	})
 }
@@ -0,0 +1,37 @@
+package pcap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePostProcess(t *testing.T) {
+	tests := []struct {
+		name        string
+		postProcess string
+		wantAction  string
+		wantDir     string
+		wantErr     bool
+	}{
+		{name: "default", postProcess: "", wantAction: "delete"},
+		{name: "delete", postProcess: "delete", wantAction: "delete"},
+		{name: "keep", postProcess: "keep", wantAction: "keep"},
+		{name: "move", postProcess: "move:/var/archive", wantAction: "move", wantDir: "/var/archive"},
+		{name: "move with no directory", postProcess: "move:", wantErr: true},
+		{name: "unknown", postProcess: "compress", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, dir, err := parsePostProcess(tt.postProcess)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantAction, action)
+			require.Equal(t, tt.wantDir, dir)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package pcap
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// packetRecord is the common intermediate representation produced by both
+// the file-based (tshark) and live (gopacket) capture sources before being
+// handed to the accumulator. Keeping a single shape here means both sources
+// agree on how tags/fields/timestamps are built instead of each having its
+// own ad hoc path to acc.AddFields.
+type packetRecord struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]interface{}
+	timestamp   time.Time
+}
+
+func (r *packetRecord) addTo(acc telegraf.Accumulator) {
+	acc.AddFields(r.measurement, r.fields, r.tags, r.timestamp)
+}
@@ -0,0 +1,197 @@
+package pcap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/influxdata/telegraf"
+)
+
+// dirWatcher watches `watch_dirs` for files matching `pattern` and hands
+// them to fileSource once they look finished, so a capture tool like
+// `dumpcap -b` or `tcpdump -G` gets its rotated-out file picked up right
+// away instead of waiting for the next polling interval. fsnotify only
+// tells us a file was created or renamed into place; we still confirm it
+// has stopped growing (a stable size across two checks) before treating it
+// as ready, since a single create/rename event fires before the writer is
+// necessarily done.
+type dirWatcher struct {
+	plugin *PCAP
+
+	fsw  *fsnotify.Watcher
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu         sync.Mutex
+	candidates map[string]int64 // path -> size as of the last sweep, -1 before the first
+	ready      []string
+	errs       []error
+}
+
+func newDirWatcher(p *PCAP) *dirWatcher {
+	return &dirWatcher{plugin: p, candidates: make(map[string]int64)}
+}
+
+func (w *dirWatcher) start() error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create fsnotify watcher: %w", err)
+	}
+
+	for _, dir := range w.plugin.WatchDirs {
+		if err := fsw.Add(dir); err != nil {
+			fsw.Close()
+			return fmt.Errorf("failed to watch directory %s: %w", dir, err)
+		}
+	}
+
+	w.fsw = fsw
+	w.done = make(chan struct{})
+
+	w.wg.Add(2)
+	go w.watchEvents()
+	go w.checkStability()
+
+	return nil
+}
+
+func (w *dirWatcher) stop() {
+	if w.done == nil {
+		return
+	}
+	close(w.done)
+	w.fsw.Close()
+	w.wg.Wait()
+}
+
+func (w *dirWatcher) watchEvents() {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			// Create covers both a freshly written file and one moved into
+			// the directory (MOVED_TO); Write/Rename catch renames within
+			// the watched filesystem that fsnotify reports differently
+			// across platforms.
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+				continue
+			}
+			if !w.matchesPattern(event.Name) {
+				continue
+			}
+			w.addCandidate(event.Name)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			w.mu.Lock()
+			w.errs = append(w.errs, fmt.Errorf("directory watcher error: %w", err))
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *dirWatcher) checkStability() {
+	defer w.wg.Done()
+
+	interval := time.Duration(w.plugin.WatchStableCheckInterval)
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.sweep()
+		}
+	}
+}
+
+// sweep re-stats every candidate file; one whose size matches what it was at
+// the previous sweep is promoted to ready.
+func (w *dirWatcher) sweep() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for path, lastSize := range w.candidates {
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				delete(w.candidates, path)
+				continue
+			}
+			w.errs = append(w.errs, fmt.Errorf("failed to stat %s: %w", path, err))
+			continue
+		}
+
+		if info.Size() == lastSize {
+			delete(w.candidates, path)
+			w.ready = append(w.ready, path)
+			continue
+		}
+		w.candidates[path] = info.Size()
+	}
+}
+
+func (w *dirWatcher) addCandidate(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, exists := w.candidates[path]; exists {
+		return
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		// The file may already have been moved/removed by the time we get
+		// around to stat-ing it; nothing to track in that case.
+		return
+	}
+	// No size is recorded yet: the first sweep only takes a baseline, so a
+	// file must keep the same size across two sweeps before it is ready.
+	w.candidates[path] = -1
+}
+
+func (w *dirWatcher) matchesPattern(path string) bool {
+	pattern := w.plugin.Pattern
+	if pattern == "" {
+		pattern = "*.pcap"
+	}
+	matched, _ := filepath.Match(pattern, filepath.Base(path))
+	return matched
+}
+
+// drainReady returns every file that became stable since the last call and
+// clears the ready list.
+func (w *dirWatcher) drainReady() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ready := w.ready
+	w.ready = nil
+	return ready
+}
+
+func (w *dirWatcher) drainErrors(acc telegraf.Accumulator) {
+	w.mu.Lock()
+	errs := w.errs
+	w.errs = nil
+	w.mu.Unlock()
+
+	for _, err := range errs {
+		acc.AddError(err)
+	}
+}
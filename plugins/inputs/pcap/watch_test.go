@@ -0,0 +1,64 @@
+package pcap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirWatcherSweepPromotesStableFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcap")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0644))
+
+	w := newDirWatcher(&PCAP{WatchDirs: []string{dir}, Pattern: "*.pcap"})
+	w.addCandidate(path)
+
+	// Grow the file between the candidate being added and the first sweep,
+	// as a writer still appending to it would: the first sweep only records
+	// a baseline size rather than promoting the file.
+	require.NoError(t, os.WriteFile(path, []byte("abcdefg"), 0644))
+	w.sweep()
+	require.Empty(t, w.drainReady(), "first sweep only records a baseline, file isn't stable yet")
+
+	w.sweep()
+	require.Equal(t, []string{path}, w.drainReady(), "size unchanged across two sweeps, file should be promoted")
+}
+
+func TestDirWatcherSweepKeepsTrackingGrowingFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcap")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0644))
+
+	w := newDirWatcher(&PCAP{WatchDirs: []string{dir}, Pattern: "*.pcap"})
+	w.addCandidate(path)
+	w.sweep()
+
+	require.NoError(t, os.WriteFile(path, []byte("abcdefg"), 0644))
+	w.sweep()
+
+	require.Empty(t, w.drainReady(), "file grew between sweeps, should not be promoted yet")
+	require.Contains(t, w.candidates, path)
+}
+
+func TestDirWatcherSweepDropsRemovedFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capture.pcap")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0644))
+
+	w := newDirWatcher(&PCAP{WatchDirs: []string{dir}, Pattern: "*.pcap"})
+	w.addCandidate(path)
+	require.NoError(t, os.Remove(path))
+
+	w.sweep()
+	require.Empty(t, w.drainReady())
+	require.NotContains(t, w.candidates, path)
+}
+
+func TestDirWatcherMatchesPattern(t *testing.T) {
+	w := newDirWatcher(&PCAP{Pattern: "*.pcap"})
+	require.True(t, w.matchesPattern("/var/captures/eth0_00001.pcap"))
+	require.False(t, w.matchesPattern("/var/captures/eth0_00001.pcapng"))
+}